@@ -2,37 +2,73 @@ package fzf
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"encoding/binary"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/charlievieth/fastwalk"
+	"github.com/fsnotify/fsnotify"
 	"github.com/junegunn/fzf/src/util"
 )
 
 // Reader reads from command or standard input
 type Reader struct {
-	pusher   func([]byte) bool
-	eventBox *util.EventBox
-	delimNil bool
-	event    int32
-	finChan  chan bool
-	mutex    sync.Mutex
-	exec     *exec.Cmd
-	command  *string
-	killed   bool
-	wait     bool
+	pusher       func([]byte) bool
+	eventBox     *util.EventBox
+	delimNil     bool
+	event        int32
+	finChan      chan bool
+	mutex        sync.Mutex
+	exec         *exec.Cmd
+	command      *string
+	killed       bool
+	wait         bool
+	decompress   string
+	watch        []string
+	watcher      *fsnotify.Watcher
+	framed       bool
+	maxFrameSize int
+	showStats    bool
+	startTime    time.Time
+	bytesRead    int64
+	itemsPushed  int64
+	lastProgress int64
+	reloading    int32
+	reloadQueued int32
 }
 
+// framedMagic optionally precedes a framed input stream, followed by a
+// 4-byte version, so producers can identify themselves to a consumer.
+var framedMagic = []byte("FZF1")
+
+// framedMaxSizeDefault bounds a single frame's length when the reader
+// wasn't given an explicit limit, guarding against a corrupt or hostile
+// length prefix forcing a huge allocation.
+const framedMaxSizeDefault = 512 * 1024 * 1024
+
+// largeItemThresholdDefault is how big a single item has to be, in bytes,
+// before feed and feedFramed stop routing it through the slab at all and
+// give it its own allocation instead. This is independent of the slab's
+// own maxItem anti-fragmentation guard (which is sized off the read
+// buffer, not the item); it exists so one huge item - a JSON blob, a log
+// line with an embedded stack trace - can't tie down a slab section that
+// every other item is also trying to borrow from. Override with
+// FZF_LARGE_ITEM_KB.
+const largeItemThresholdDefault = 4 * 1024 * 1024
+
 // NewReader returns new Reader object
-func NewReader(pusher func([]byte) bool, eventBox *util.EventBox, delimNil bool, wait bool) *Reader {
-	return &Reader{pusher, eventBox, delimNil, int32(EvtReady), make(chan bool, 1), sync.Mutex{}, nil, nil, false, wait}
+func NewReader(pusher func([]byte) bool, eventBox *util.EventBox, delimNil bool, wait bool, decompress string, watch []string, framed bool, maxFrameSize int, showStats bool) *Reader {
+	return &Reader{pusher, eventBox, delimNil, int32(EvtReady), make(chan bool, 1), sync.Mutex{}, nil, nil, false, wait, decompress, watch, nil, framed, maxFrameSize, showStats, time.Time{}, 0, 0, 0, 0, 0}
 }
 
 func (r *Reader) startEventPoller() {
@@ -78,6 +114,10 @@ func (r *Reader) fin(success bool) {
 func (r *Reader) terminate() {
 	r.mutex.Lock()
 	r.killed = true
+	if r.watcher != nil {
+		r.watcher.Close()
+		r.watcher = nil
+	}
 	if r.exec != nil && r.exec.Process != nil {
 		util.KillCommand(r.exec)
 	} else {
@@ -86,32 +126,96 @@ func (r *Reader) terminate() {
 	r.mutex.Unlock()
 }
 
-func (r *Reader) restart(command string, environ []string) {
+// rerun resets the reader for another pass over fn, e.g. from a manual
+// restart or a watch-triggered reload, and reports completion the same way
+// the initial read did.
+func (r *Reader) rerun(fn func() bool) {
 	r.event = int32(EvtReady)
+	r.startTime = time.Now()
+	atomic.StoreInt64(&r.bytesRead, 0)
+	atomic.StoreInt64(&r.itemsPushed, 0)
 	r.startEventPoller()
-	success := r.readFromCommand(command, environ)
+	success := fn()
 	r.fin(success)
 }
 
+func (r *Reader) restart(command string, environ []string) {
+	r.rerun(func() bool { return r.readFromCommand(command, environ) })
+}
+
+// triggerReload is how a watch-triggered debounce timer asks for a reload.
+// It single-flights: if a reload is already running, this one is recorded
+// as queued instead of starting a second, concurrent rerun (rerun isn't
+// reentrant - it resets the shared event state and progress counters and
+// starts a new event poller), and the running reload picks it up once it
+// finishes. A
+// terminated reader (killed under r.mutex) never reloads again.
+func (r *Reader) triggerReload(reload func() bool) {
+	r.mutex.Lock()
+	killed := r.killed
+	r.mutex.Unlock()
+	if killed {
+		return
+	}
+
+	if !atomic.CompareAndSwapInt32(&r.reloading, 0, 1) {
+		atomic.StoreInt32(&r.reloadQueued, 1)
+		return
+	}
+
+	go func() {
+		for {
+			r.mutex.Lock()
+			killed := r.killed
+			r.mutex.Unlock()
+			if killed {
+				break
+			}
+			r.rerun(reload)
+			if !atomic.CompareAndSwapInt32(&r.reloadQueued, 1, 0) {
+				break
+			}
+		}
+		atomic.StoreInt32(&r.reloading, 0)
+	}()
+}
+
 // ReadSource reads data from the default command or from standard input
 func (r *Reader) ReadSource(root string, opts walkerOpts, ignores []string) {
+	r.startTime = time.Now()
 	r.startEventPoller()
 	var success bool
+	var reload func() bool
 	if util.IsTty() {
 		cmd := os.Getenv("FZF_DEFAULT_COMMAND")
 		if len(cmd) == 0 {
-			success = r.readFiles(root, opts, ignores)
+			reload = func() bool { return r.readFiles(root, opts, ignores) }
 		} else {
 			// We can't export FZF_* environment variables to the default command
-			success = r.readFromCommand(cmd, nil)
+			reload = func() bool { return r.readFromCommand(cmd, nil) }
 		}
 	} else {
-		success = r.readFromStdin()
+		reload = r.readFromStdin
 	}
+	success = reload()
+
+	watch := r.watch
+	if len(watch) == 0 {
+		if env := os.Getenv("FZF_WATCH"); len(env) > 0 {
+			watch = strings.Split(env, ",")
+		}
+	}
+	if len(watch) > 0 && !r.killed {
+		r.startWatch(watch, opts, ignores, reload)
+	}
+
 	r.fin(success)
 }
 
-func (r *Reader) feed(src io.Reader) {
+// feed returns false when it had to give up on a corrupt or hostile input
+// (currently only the framed protocol can detect this), so the caller can
+// report the read as failed instead of as a clean EOF.
+func (r *Reader) feed(src io.Reader) bool {
 	readerSlabSize, ae := strconv.Atoi(os.Getenv("SLAB_KB"))
 	if ae != nil {
 		readerSlabSize = 128 * 1024
@@ -124,9 +228,6 @@ func (r *Reader) feed(src io.Reader) {
 	} else {
 		readerBufferSize *= 1024
 	}
-
-	slab := make([]byte, readerSlabSize)
-	pointer := 0
 	delim := byte('\n')
 	if r.delimNil {
 		delim = '\000'
@@ -135,6 +236,16 @@ func (r *Reader) feed(src io.Reader) {
 
 	// We do not put a slice longer than 10% of the slab to reduce fragmentation
 	maxBytes := readerBufferSize / 10
+	slab := newSlabAllocator(readerSlabSize, maxBytes)
+
+	largeItemThreshold := largeItemThresholdDefault
+	if kb, err := strconv.Atoi(os.Getenv("FZF_LARGE_ITEM_KB")); err == nil && kb > 0 {
+		largeItemThreshold = kb * 1024
+	}
+
+	if r.framed {
+		return r.feedFramed(reader, slab, largeItemThreshold)
+	}
 
 	for {
 		var frags [][]byte
@@ -160,19 +271,15 @@ func (r *Reader) feed(src io.Reader) {
 				}
 
 				itemLen := fragsLen + byteaLen
-				pointer += itemLen
 				var slice []byte
-				if itemLen <= maxBytes { // We can use the slab
-					// Allocate a new slab if it doesn't fit
-					if pointer > readerSlabSize {
-						slab = make([]byte, readerSlabSize)
-						pointer = itemLen
-					}
-					slice = slab[pointer-itemLen : pointer]
-				} else { // We can't use the slab because the item is too large
+				if itemLen > largeItemThreshold {
+					// Large enough that sharing the slab isn't worth it -
+					// give it its own allocation instead of pinning a slab
+					// section that other, smaller items are waiting on.
 					slice = make([]byte, itemLen)
+				} else {
+					slice = slab.alloc(itemLen)
 				}
-
 				if len(frags) > 0 {
 					// Collect the fragments
 					n := 0
@@ -183,11 +290,14 @@ func (r *Reader) feed(src io.Reader) {
 				} else if byteaLen > 0 {
 					copy(slice, bytea)
 				}
-				if (err == nil || itemLen > 0) && r.pusher(slice) {
-					atomic.StoreInt32(&r.event, int32(EvtReadNew))
+				if err == nil || itemLen > 0 {
+					r.trackProgress(itemLen)
+					if r.pusher(slice) {
+						atomic.StoreInt32(&r.event, int32(EvtReadNew))
+					}
 				}
 				if err != nil {
-					return
+					return true
 				}
 				break
 			}
@@ -195,14 +305,87 @@ func (r *Reader) feed(src io.Reader) {
 	}
 }
 
+// feedFramed implements the --input-format=frames protocol: each record is
+// a 4-byte little-endian length prefix followed by that many raw bytes, so
+// producers can send arbitrary bytes (including newlines and NULs) without
+// escaping. An optional 4-byte magic + 4-byte version header lets producers
+// identify themselves; it is consumed if present and ignored otherwise. It
+// returns false on a frame that's oversized or truncated, as opposed to a
+// clean end of stream between frames, so a corrupt or hostile producer is
+// reported as a failed read rather than silently dropping the rest of the
+// stream. Frames at or under largeItemThreshold go through slab.alloc
+// (which itself gives frames over the slab's maxItem their own
+// allocation); larger ones get their own allocation, same as in feed.
+func (r *Reader) feedFramed(reader *bufio.Reader, slab *slabAllocator, largeItemThreshold int) bool {
+	if head, err := reader.Peek(len(framedMagic)); err == nil && bytes.Equal(head, framedMagic) {
+		reader.Discard(len(framedMagic))
+		version := make([]byte, 4)
+		if _, err := io.ReadFull(reader, version); err != nil {
+			return false
+		}
+	}
+
+	maxFrameSize := r.maxFrameSize
+	if maxFrameSize <= 0 {
+		maxFrameSize = framedMaxSizeDefault
+	}
+
+	lenBuf := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(reader, lenBuf); err != nil {
+			// A length prefix cut off mid-read is a truncated stream, not
+			// a clean end; only a read that starts exactly on a frame
+			// boundary is a successful finish.
+			return err == io.EOF
+		}
+		itemLen := int(binary.LittleEndian.Uint32(lenBuf))
+		if itemLen > maxFrameSize {
+			return false
+		}
+
+		var slice []byte
+		if itemLen > largeItemThreshold {
+			slice = make([]byte, itemLen)
+		} else {
+			slice = slab.alloc(itemLen)
+		}
+		if _, err := io.ReadFull(reader, slice); err != nil {
+			return false
+		}
+
+		r.trackProgress(itemLen)
+		if r.pusher(slice) {
+			atomic.StoreInt32(&r.event, int32(EvtReadNew))
+		}
+	}
+}
+
 func (r *Reader) readFromStdin() bool {
-	r.feed(os.Stdin)
-	return true
+	rc := wrapDecompressor(r.decompress, os.Stdin)
+	defer rc.Close()
+	return r.feed(rc)
 }
 
 func (r *Reader) readFiles(root string, opts walkerOpts, ignores []string) bool {
 	r.killed = false
-	conf := fastwalk.Config{Follow: opts.follow}
+
+	ignoreSet := make(map[string]struct{}, len(ignores))
+	for _, ignore := range ignores {
+		ignoreSet[ignore] = struct{}{}
+	}
+
+	var gitIgnore *gitIgnoreMatcher
+	if opts.respectGitignore {
+		gitIgnore = loadStackedGitignore(root)
+	}
+
+	conf := fastwalk.Config{Follow: opts.follow, NumWorkers: runtime.NumCPU()}
+
+	// fastwalk invokes fn from NumWorkers goroutines concurrently, but
+	// r.pusher isn't safe for concurrent calls, so each call is serialized
+	// through pushMutex instead of widening the scope of r.mutex (which
+	// guards killed/exec and is also read from ReadSource/terminate).
+	var pushMutex sync.Mutex
 	fn := func(path string, de os.DirEntry, err error) error {
 		if err != nil {
 			return nil
@@ -215,14 +398,32 @@ func (r *Reader) readFiles(root string, opts walkerOpts, ignores []string) bool
 				if !opts.hidden && base[0] == '.' {
 					return filepath.SkipDir
 				}
-				for _, ignore := range ignores {
-					if ignore == base {
+				if _, skip := ignoreSet[base]; skip {
+					return filepath.SkipDir
+				}
+			}
+			if gitIgnore != nil {
+				if gitIgnore.match(path, isDir) {
+					if isDir {
 						return filepath.SkipDir
 					}
+					return nil
+				}
+				if isDir {
+					// Load this directory's own .gitignore now, before we
+					// descend into it, so its children can be matched
+					// against it too.
+					gitIgnore.visitDir(path)
 				}
 			}
-			if ((opts.file && !isDir) || (opts.dir && isDir)) && r.pusher([]byte(path)) {
-				atomic.StoreInt32(&r.event, int32(EvtReadNew))
+			if (opts.file && !isDir) || (opts.dir && isDir) {
+				pushMutex.Lock()
+				r.trackProgress(len(path))
+				pushed := r.pusher([]byte(path))
+				pushMutex.Unlock()
+				if pushed {
+					atomic.StoreInt32(&r.event, int32(EvtReadNew))
+				}
 			}
 		}
 		r.mutex.Lock()
@@ -253,6 +454,8 @@ func (r *Reader) readFromCommand(command string, environ []string) bool {
 	if err != nil {
 		return false
 	}
-	r.feed(out)
-	return r.exec.Wait() == nil
+	rc := wrapDecompressor(r.decompress, out)
+	defer rc.Close()
+	ok := r.feed(rc)
+	return r.exec.Wait() == nil && ok
 }
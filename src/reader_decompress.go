@@ -0,0 +1,74 @@
+package fzf
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// decompressMagic maps known stream signatures to the codec that produced
+// them, so "auto" mode can sniff a source without being told its shape.
+var decompressMagic = []struct {
+	codec string
+	magic []byte
+}{
+	{"gzip", []byte{0x1f, 0x8b}},
+	{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{"xz", []byte{0xfd, 0x37, 0x7a, 0x58, 0x5a, 0x00}},
+	{"snappy", []byte{0xff, 0x06, 0x00, 0x00, 0x73, 0x4e, 0x61, 0x50, 0x70, 0x59}},
+}
+
+// detectCodec peeks at the head of buf and returns the name of the codec
+// whose magic header matches, or "" if none do.
+func detectCodec(buf *bufio.Reader) string {
+	head, _ := buf.Peek(10)
+	for _, m := range decompressMagic {
+		if len(head) >= len(m.magic) && bytes.Equal(head[:len(m.magic)], m.magic) {
+			return m.codec
+		}
+	}
+	return ""
+}
+
+// wrapDecompressor wraps src in a decoder selected by mode, which is one of
+// "", "auto" (sniff the stream), "none" (disable detection), or an explicit
+// codec name ("gzip", "zstd", "xz", "snappy") as set via --decompress. It
+// falls through to the raw (buffered) stream when no codec applies or the
+// decoder fails to initialize. The gzip and xz decoders read their header
+// from the stream while initializing, so after such a failure the header
+// bytes they consumed are missing from the raw stream.
+// The caller owns the returned ReadCloser and must Close it once done
+// feeding from it - zstd in particular starts background goroutines that
+// only stop on Close.
+func wrapDecompressor(mode string, src io.Reader) io.ReadCloser {
+	if mode == "none" {
+		return io.NopCloser(src)
+	}
+	buf := bufio.NewReaderSize(src, 64*1024)
+	codec := mode
+	if mode == "" || mode == "auto" {
+		codec = detectCodec(buf)
+	}
+	switch codec {
+	case "gzip":
+		if gz, err := gzip.NewReader(buf); err == nil {
+			return gz
+		}
+	case "zstd":
+		if zr, err := zstd.NewReader(buf); err == nil {
+			return zr.IOReadCloser()
+		}
+	case "xz":
+		if xr, err := xz.NewReader(buf); err == nil {
+			return io.NopCloser(xr)
+		}
+	case "snappy":
+		return io.NopCloser(snappy.NewReader(buf))
+	}
+	return io.NopCloser(buf)
+}
@@ -0,0 +1,117 @@
+package fzf
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	ignore "github.com/sabhiram/go-gitignore"
+)
+
+// gitIgnoreMatcher answers whether a path under root is ignored. It starts
+// out seeded with root's own .gitignore and every ancestor's above it, and
+// is filled in further as the walk descends: visitDir loads a
+// subdirectory's .gitignore the moment readFiles visits it, so a
+// package-local .gitignore several levels under root still applies to its
+// own subtree, exactly like git itself.
+type gitIgnoreMatcher struct {
+	root    string // root as given to the walk, cleaned
+	rootAbs string
+
+	mu     sync.RWMutex
+	levels map[string]*ignore.GitIgnore // absolute dir -> compiled rules (nil cached for "no .gitignore here")
+}
+
+// loadStackedGitignore resolves root to an absolute path once and seeds the
+// matcher with root's own .gitignore plus every ancestor's above it.
+// Returns nil only when root itself can't be resolved.
+func loadStackedGitignore(root string) *gitIgnoreMatcher {
+	rootAbs, err := filepath.Abs(root)
+	if err != nil {
+		return nil
+	}
+
+	m := &gitIgnoreMatcher{root: filepath.Clean(root), rootAbs: rootAbs, levels: map[string]*ignore.GitIgnore{}}
+	for dir := rootAbs; ; {
+		m.levels[dir] = compileGitignore(dir)
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return m
+}
+
+// compileGitignore compiles dir's own .gitignore, or returns nil if it
+// doesn't have one.
+func compileGitignore(dir string) *ignore.GitIgnore {
+	data, err := os.ReadFile(filepath.Join(dir, ".gitignore"))
+	if err != nil {
+		return nil
+	}
+	return ignore.CompileIgnoreLines(strings.Split(string(data), "\n")...)
+}
+
+// visitDir loads path's own .gitignore, if any, so later sibling/child
+// entries under it can be matched against it. Safe to call from multiple
+// fastwalk workers concurrently; idempotent per directory.
+func (m *gitIgnoreMatcher) visitDir(path string) {
+	abs := m.toAbs(path)
+
+	m.mu.RLock()
+	_, ok := m.levels[abs]
+	m.mu.RUnlock()
+	if ok {
+		return
+	}
+
+	rules := compileGitignore(abs)
+	m.mu.Lock()
+	m.levels[abs] = rules
+	m.mu.Unlock()
+}
+
+// toAbs converts a path produced by walking root into an absolute path by
+// trimming root's own already-known prefix and rejoining against rootAbs.
+// This avoids a syscall-backed filepath.Abs (which calls os.Getwd for a
+// relative path) on every entry of a hot, parallel walk.
+func (m *gitIgnoreMatcher) toAbs(path string) string {
+	path = filepath.Clean(path)
+	if m.root == "." {
+		return filepath.Join(m.rootAbs, path)
+	}
+	rel := strings.TrimPrefix(path, m.root)
+	rel = strings.TrimPrefix(rel, string(filepath.Separator))
+	return filepath.Join(m.rootAbs, rel)
+}
+
+// match reports whether path (as produced by fastwalk over root) is
+// ignored, checked from its nearest ancestor .gitignore outward so a
+// deeper, more specific file is consulted first.
+func (m *gitIgnoreMatcher) match(path string, isDir bool) bool {
+	abs := m.toAbs(path)
+	for dir := filepath.Dir(abs); ; {
+		m.mu.RLock()
+		rules := m.levels[dir]
+		m.mu.RUnlock()
+
+		if rules != nil {
+			if rel, err := filepath.Rel(dir, abs); err == nil && !strings.HasPrefix(rel, "..") {
+				if isDir {
+					rel += "/"
+				}
+				if rules.MatchesPath(rel) {
+					return true
+				}
+			}
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return false
+		}
+		dir = parent
+	}
+}
@@ -0,0 +1,34 @@
+package fzf
+
+// slabAllocator hands out slices backed by a reusable buffer for items
+// small enough not to fragment it, and a dedicated allocation for anything
+// larger. feed and feedFramed share one so neither parsing style pays for
+// its own bookkeeping.
+type slabAllocator struct {
+	buf     []byte
+	size    int
+	pointer int
+	maxItem int
+}
+
+// newSlabAllocator returns a slabAllocator backed by a buffer of size
+// bytes; items larger than maxItem always get their own allocation to
+// avoid fragmenting the slab.
+func newSlabAllocator(size int, maxItem int) *slabAllocator {
+	return &slabAllocator{buf: make([]byte, size), size: size, maxItem: maxItem}
+}
+
+// alloc returns a slice of length n, backed by the slab when possible.
+func (s *slabAllocator) alloc(n int) []byte {
+	if n > s.maxItem {
+		return make([]byte, n)
+	}
+	// Allocate a new slab if it doesn't fit
+	if s.pointer+n > s.size {
+		s.buf = make([]byte, s.size)
+		s.pointer = 0
+	}
+	slice := s.buf[s.pointer : s.pointer+n]
+	s.pointer += n
+	return slice
+}
@@ -0,0 +1,49 @@
+package fzf
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// readerProgressInterval throttles how often feed publishes an
+// EvtReadProgress event while a read is in flight.
+const readerProgressInterval = 200 * time.Millisecond
+
+// ReadStats is a snapshot of a Reader's progress, returned by Stats and
+// published via EvtReadProgress so the UI can render e.g.
+// "1,234,567 items · 987.6 MiB · 42.1 MiB/s" with go-humanize.
+type ReadStats struct {
+	Bytes   int64
+	Items   int64
+	Elapsed time.Duration
+}
+
+// Stats returns a snapshot of bytes read and items pushed so far. The
+// counters are atomic so the poller in startEventPoller never has to
+// serialize with feed.
+func (r *Reader) Stats() ReadStats {
+	return ReadStats{
+		Bytes:   atomic.LoadInt64(&r.bytesRead),
+		Items:   atomic.LoadInt64(&r.itemsPushed),
+		Elapsed: time.Since(r.startTime),
+	}
+}
+
+// trackProgress records an item of the given length as read and, when
+// --show-input-stats is on, publishes a throttled progress snapshot.
+func (r *Reader) trackProgress(itemLen int) {
+	atomic.AddInt64(&r.bytesRead, int64(itemLen))
+	atomic.AddInt64(&r.itemsPushed, 1)
+
+	if !r.showStats {
+		return
+	}
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&r.lastProgress)
+	if now-last < int64(readerProgressInterval) {
+		return
+	}
+	if atomic.CompareAndSwapInt64(&r.lastProgress, last, now) {
+		r.eventBox.Set(EvtReadProgress, r.Stats())
+	}
+}
@@ -0,0 +1,93 @@
+package fzf
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounceDefault is how long startWatch waits for a burst of
+// filesystem events to settle before triggering a reload.
+const watchDebounceDefault = 100 * time.Millisecond
+
+// startWatch subscribes to filesystem change events under roots (enabled
+// via --watch=PATH[,PATH...] or FZF_WATCH) and re-runs reload, debounced,
+// whenever something changes. It honors the same hidden/ignores filters as
+// readFiles so watched trees and listed trees stay in sync.
+func (r *Reader) startWatch(roots []string, opts walkerOpts, ignores []string, reload func() bool) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return
+	}
+	for _, root := range roots {
+		addWatchRecursive(watcher, root, opts, ignores)
+	}
+
+	r.mutex.Lock()
+	if r.killed {
+		r.mutex.Unlock()
+		watcher.Close()
+		return
+	}
+	r.watcher = watcher
+	r.mutex.Unlock()
+
+	debounce := watchDebounceDefault
+	if ms, err := strconv.Atoi(os.Getenv("FZF_WATCH_DEBOUNCE_MS")); err == nil && ms > 0 {
+		debounce = time.Duration(ms) * time.Millisecond
+	}
+
+	go func() {
+		var timer *time.Timer
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op&fsnotify.Create != 0 {
+					if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+						addWatchRecursive(watcher, event.Name, opts, ignores)
+					}
+				}
+				if timer == nil {
+					timer = time.AfterFunc(debounce, func() { r.triggerReload(reload) })
+				} else {
+					timer.Reset(debounce)
+				}
+			case _, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+			}
+		}
+	}()
+}
+
+// addWatchRecursive adds watches for root and every subdirectory beneath
+// it that survives the hidden/ignores filter, so later fsnotify.Create
+// events for newly created subdirectories can be picked up in turn.
+func addWatchRecursive(watcher *fsnotify.Watcher, root string, opts walkerOpts, ignores []string) {
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		if path != root {
+			base := filepath.Base(path)
+			if !opts.hidden && strings.HasPrefix(base, ".") {
+				return filepath.SkipDir
+			}
+			for _, ignore := range ignores {
+				if ignore == base {
+					return filepath.SkipDir
+				}
+			}
+		}
+		watcher.Add(path)
+		return nil
+	})
+}